@@ -0,0 +1,104 @@
+// Package random provides deterministic, seed-based random selection over a weighted set of items.
+package random
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"sort"
+)
+
+// Comparable lets items break ties when every Entry handed to NewWeightedRandom has the same (or zero) weight.
+// Compare should report whether the receiver sorts before other.
+type Comparable interface {
+	Compare(other Comparable) bool
+}
+
+// Entry is a single item and the weight it should be picked with, relative to the other Entries passed to
+// NewWeightedRandom.
+type Entry struct {
+	Item   Comparable
+	Weight float64
+}
+
+type weightedRange struct {
+	item Comparable
+	low  float64
+	high float64
+}
+
+// WeightedRandom deterministically picks items proportional to the weight they were constructed with: the same
+// seed always yields the same pick.
+type WeightedRandom struct {
+	// entries holds the same Entries NewWeightedRandom was given, sorted by their Comparable tie-break.
+	entries []Entry
+	ranges  []weightedRange
+}
+
+// NewWeightedRandom builds a WeightedRandom over entries. Entries are sorted using their Comparable implementation
+// so that, for a given seed, the same logical set of entries always maps to the same pick regardless of the order
+// they're passed in. Returns an error if any entry has a negative weight.
+func NewWeightedRandom(entries []Entry) (*WeightedRandom, error) {
+	sorted := make([]Entry, len(entries))
+	copy(sorted, entries)
+
+	total := 0.0
+	for _, e := range sorted {
+		if e.Weight < 0 {
+			return nil, fmt.Errorf("invalid weight %f", e.Weight)
+		}
+		total += e.Weight
+	}
+
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Item.Compare(sorted[j].Item)
+	})
+
+	ranges := make([]weightedRange, len(sorted))
+	low := 0.0
+	if total == 0 {
+		// All weights are zero (or there are no entries): fall back to splitting the [0, 1) range evenly across
+		// the sorted entries as a tie-break.
+		step := 1.0 / float64(len(sorted))
+		for i, e := range sorted {
+			ranges[i] = weightedRange{item: e.Item, low: low, high: low + step}
+			low += step
+		}
+	} else {
+		for i, e := range sorted {
+			high := low + e.Weight/total
+			ranges[i] = weightedRange{item: e.Item, low: low, high: high}
+			low = high
+		}
+	}
+
+	return &WeightedRandom{entries: sorted, ranges: ranges}, nil
+}
+
+// seededRand returns a *rand.Rand deterministically derived from seed: the same seed always produces the same
+// sequence of draws.
+func seededRand(seed string) *rand.Rand {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(seed))
+	// #nosec G115 -- truncation is intentional, we only need a deterministic seed value, not the exact hash.
+	return rand.New(rand.NewSource(int64(h.Sum64())))
+}
+
+// GetWithSeed deterministically picks a single item proportional to its weight: the same seed always returns the
+// same item.
+func (w *WeightedRandom) GetWithSeed(seed string) (Comparable, error) {
+	if len(w.ranges) == 0 {
+		return nil, fmt.Errorf("no entries to pick from")
+	}
+
+	x := seededRand(seed).Float64()
+	for _, r := range w.ranges {
+		if x >= r.low && x < r.high {
+			return r.item, nil
+		}
+	}
+
+	// Floating point rounding can leave the final range's high just below 1, so x can land past it; fall back to
+	// the last entry.
+	return w.ranges[len(w.ranges)-1].item, nil
+}
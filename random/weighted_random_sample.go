@@ -0,0 +1,91 @@
+package random
+
+import (
+	"container/heap"
+	"fmt"
+	"math"
+)
+
+// aresKey is a single candidate's A-Res priority together with the item it came from.
+type aresKey struct {
+	key  float64
+	item Comparable
+}
+
+// aresMinHeap keeps the n largest aresKeys seen so far: the smallest of those n sits at the root, ready to be
+// evicted the moment a bigger key shows up.
+type aresMinHeap []aresKey
+
+func (h aresMinHeap) Len() int            { return len(h) }
+func (h aresMinHeap) Less(i, j int) bool  { return h[i].key < h[j].key }
+func (h aresMinHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *aresMinHeap) Push(x interface{}) { *h = append(*h, x.(aresKey)) }
+func (h *aresMinHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	popped := old[n-1]
+	*h = old[:n-1]
+	return popped
+}
+
+// GetNWithSeed returns n distinct items sampled without replacement, proportional to their weight, using the A-Res
+// weighted reservoir algorithm (Efraimidis-Spirakis): every positive-weight entry draws a key u_i^(1/w_i) from a
+// rand.Rand seeded deterministically from seed, and the n entries with the largest keys are returned. As with
+// GetWithSeed, the same seed always returns the same sample.
+//
+// If every entry has zero weight, this falls back to the same Comparable-based tie-break NewWeightedRandom sorts
+// entries with, returning the first n of them. Returns an error if n exceeds the number of entries available to
+// sample from (positive-weight entries, or all entries in the all-zero-weight fallback).
+func (w *WeightedRandom) GetNWithSeed(seed string, n int) ([]Comparable, error) {
+	if n <= 0 {
+		return nil, fmt.Errorf("n must be positive, got %d", n)
+	}
+
+	positive := 0
+	for _, e := range w.entries {
+		if e.Weight > 0 {
+			positive++
+		}
+	}
+
+	if positive == 0 {
+		if n > len(w.entries) {
+			return nil, fmt.Errorf("cannot sample %d distinct entries out of %d", n, len(w.entries))
+		}
+
+		picked := make([]Comparable, n)
+		for i := 0; i < n; i++ {
+			picked[i] = w.entries[i].Item
+		}
+		return picked, nil
+	}
+
+	if n > positive {
+		return nil, fmt.Errorf("cannot sample %d distinct entries out of %d with positive weight", n, positive)
+	}
+
+	rnd := seededRand(seed)
+	h := make(aresMinHeap, 0, n)
+	for _, e := range w.entries {
+		if e.Weight <= 0 {
+			continue
+		}
+
+		key := math.Pow(rnd.Float64(), 1/e.Weight)
+		if h.Len() < n {
+			heap.Push(&h, aresKey{key: key, item: e.Item})
+			continue
+		}
+
+		if key > h[0].key {
+			heap.Pop(&h)
+			heap.Push(&h, aresKey{key: key, item: e.Item})
+		}
+	}
+
+	picked := make([]Comparable, h.Len())
+	for i, k := range h {
+		picked[i] = k.item
+	}
+	return picked, nil
+}
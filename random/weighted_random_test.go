@@ -1,6 +1,7 @@
 package random
 
 import (
+	"fmt"
 	"strings"
 	"testing"
 
@@ -167,3 +168,80 @@ func TestDeterministicWeightInvalidWeights(t *testing.T) {
 	assert.NotNil(t, err)
 	assert.EqualError(t, err, "invalid weight -3.000000")
 }
+
+func TestGetNWithSeedDeterministic(t *testing.T) {
+	entries := []Entry{
+		{Item: testData{key: "key1", val: 1}, Weight: 0.1},
+		{Item: testData{key: "key2", val: 2}, Weight: 0.2},
+		{Item: testData{key: "key3", val: 3}, Weight: 0.3},
+		{Item: testData{key: "key4", val: 4}, Weight: 0.4},
+	}
+	rand, err := NewWeightedRandom(entries)
+	assert.Nil(t, err)
+
+	first, err := rand.GetNWithSeed("determinism", 2)
+	assert.Nil(t, err)
+	assert.Len(t, first, 2)
+
+	for i := 0; i < 10; i++ {
+		again, err := rand.GetNWithSeed("determinism", 2)
+		assert.Nil(t, err)
+		assert.Equal(t, first, again)
+	}
+}
+
+func TestGetNWithSeedProportional(t *testing.T) {
+	entries := []Entry{
+		{Item: testData{key: "heavy", val: 1}, Weight: 0.99},
+		{Item: testData{key: "light", val: 2}, Weight: 0.01},
+	}
+	rand, err := NewWeightedRandom(entries)
+	assert.Nil(t, err)
+
+	heavyPicked := 0
+	for i := 0; i < 50; i++ {
+		picked, err := rand.GetNWithSeed(fmt.Sprintf("seed-%d", i), 1)
+		assert.Nil(t, err)
+		assert.Len(t, picked, 1)
+		if picked[0].(testData).key == "heavy" {
+			heavyPicked++
+		}
+	}
+
+	// The much heavier entry should be picked far more often than the lighter one across many seeds.
+	assert.Greater(t, heavyPicked, 40)
+}
+
+func TestGetNWithSeedOversampleError(t *testing.T) {
+	entries := []Entry{
+		{Item: testData{key: "key1", val: 1}, Weight: 0.5},
+		{Item: testData{key: "key2", val: 2}, Weight: 0.5},
+	}
+	rand, err := NewWeightedRandom(entries)
+	assert.Nil(t, err)
+
+	_, err = rand.GetNWithSeed("ab", 3)
+	assert.NotNil(t, err)
+	assert.EqualError(t, err, "cannot sample 3 distinct entries out of 2 with positive weight")
+}
+
+func TestGetNWithSeedAllZeroWeightFallback(t *testing.T) {
+	item1 := testData{key: "sort_key1", val: 1}
+	item2 := testData{key: "sort_key2", val: 2}
+	entries := []Entry{
+		{Item: item1},
+		{Item: item2},
+	}
+	rand, err := NewWeightedRandom(entries)
+	assert.Nil(t, err)
+
+	picked, err := rand.GetNWithSeed("ab", 2)
+	assert.Nil(t, err)
+	// With every weight zero, GetNWithSeed falls back to the first n entries in Comparable sort order rather than
+	// sampling, so the seed has no effect on the result.
+	assert.Equal(t, []Comparable{item1, item2}, picked)
+
+	_, err = rand.GetNWithSeed("ab", 3)
+	assert.NotNil(t, err)
+	assert.EqualError(t, err, "cannot sample 3 distinct entries out of 2")
+}
@@ -0,0 +1,393 @@
+package synccache
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/lyft/flytestdlib/utils"
+
+	lru "github.com/hashicorp/golang-lru"
+	"github.com/lyft/flytestdlib/logger"
+	"github.com/lyft/flytestdlib/promutils"
+	"k8s.io/client-go/util/workqueue"
+)
+
+// CreateBatchesFunc partitions a snapshot of tracked items into the Batches that will be handed out to the parallel
+// workers. Implementations typically group items so that each Batch maps to a single upstream bulk-lookup call,
+// e.g. chunking ids into groups of a fixed max size.
+type CreateBatchesFunc func(ctx context.Context, items []CacheItem) ([]Batch, error)
+
+// autoRefreshBatchedCache is a variant of autoRefreshCache that resyncs in batches instead of one item at a time.
+// On every resyncPeriod tick, the current snapshot of tracked items is split into Batches by createBatches, and
+// each Batch is pushed onto a workqueue that parallelism worker goroutines drain concurrently. This lets a single
+// SyncFunction call refresh many items at once against a backend that supports bulk lookups, instead of issuing one
+// call per tracked item per resync period.
+type autoRefreshBatchedCache struct {
+	name              string
+	createBatches     CreateBatchesFunc
+	syncCb            SyncFunction
+	lruMap            *lru.Cache
+	syncRateLimiter   utils.RateLimiter
+	resyncPeriod      time.Duration
+	parallelism       int
+	scope             promutils.Scope
+	metrics           *metrics
+	workqueue         workqueue.RateLimitingInterface
+	ttl               time.Duration
+	terminalRetention time.Duration
+	watchers          *watchRegistry
+
+	// generation is bumped on every call to Start; see autoRefreshCache.generation.
+	generation uint64
+
+	// inFlight tracks item ids currently being synced as part of some Batch, guarding against two batches that
+	// share an id (e.g. from overlapping generations, or from createBatches grouping the same still-unsynced id
+	// differently across resync ticks) both calling syncCb for it at once.
+	inFlight sync.Map
+
+	// shutdownOnce guards the workqueue's ShutDown against being called more than once, since every Start call
+	// registers its own ctx.Done() goroutine that calls it.
+	shutdownOnce sync.Once
+}
+
+func (w *autoRefreshBatchedCache) setTTL(d time.Duration) {
+	w.ttl = d
+}
+
+func (w *autoRefreshBatchedCache) setTerminalRetention(d time.Duration) {
+	w.terminalRetention = d
+}
+
+func (w *autoRefreshBatchedCache) Start(ctx context.Context) {
+	gen := atomic.AddUint64(&w.generation, 1)
+
+	for i := 0; i < w.parallelism; i++ {
+		go w.runWorker(ctx, gen)
+	}
+
+	go func() {
+		<-ctx.Done()
+		w.shutdownOnce.Do(w.workqueue.ShutDown)
+	}()
+
+	go w.runResyncLoop(ctx, gen)
+}
+
+func (w *autoRefreshBatchedCache) runResyncLoop(ctx context.Context, gen uint64) {
+	ticker := time.NewTicker(w.resyncPeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if atomic.LoadUint64(&w.generation) != gen {
+				return
+			}
+			w.enqueueBatches(ctx)
+		}
+	}
+}
+
+func (w *autoRefreshBatchedCache) Get(id string) (CacheItem, error) {
+	if val, ok := w.lruMap.Get(id); ok {
+		if w.metrics != nil {
+			w.metrics.CacheHit.Inc()
+		}
+		wrapper := val.(cacheItemWrapper)
+		return wrapper.CacheItem, wrapper.SyncError
+	}
+
+	if w.metrics != nil {
+		w.metrics.CacheMiss.Inc()
+	}
+	return nil, ErrNotFound
+}
+
+// Return the item if exists else create it.
+// Create should be invoked only once. recreating the object is not supported.
+func (w *autoRefreshBatchedCache) GetOrCreate(item CacheItem) (CacheItem, error) {
+	if val, ok := w.lruMap.Get(item.ID()); ok {
+		if w.metrics != nil {
+			w.metrics.CacheHit.Inc()
+		}
+		wrapper := val.(cacheItemWrapper)
+		return wrapper.CacheItem, wrapper.SyncError
+	}
+
+	if w.metrics != nil {
+		w.metrics.CacheMiss.Inc()
+	}
+	w.lruMap.Add(item.ID(), cacheItemWrapper{CacheItem: item, LastSyncedAt: time.Now()})
+	w.watchers.notify(CacheEvent{Type: Added, ID: item.ID(), New: item})
+	return item, nil
+}
+
+// Forget is a no-op for the batched cache: retries are driven by whichever Batch an item was grouped into by
+// createBatches, so there's no per-item backoff to reset.
+func (w *autoRefreshBatchedCache) Forget(_ string) {}
+
+// NumRequeues always returns 0 for the batched cache; see Forget.
+func (w *autoRefreshBatchedCache) NumRequeues(_ string) int {
+	return 0
+}
+
+// DeleteDelayed marks id for removal on the next resync, rather than removing it immediately, so in-flight Get
+// callers still see the last-known state until then.
+func (w *autoRefreshBatchedCache) DeleteDelayed(id string) error {
+	val, ok := w.lruMap.Peek(id)
+	if !ok {
+		return ErrNotFound
+	}
+
+	wrapper := val.(cacheItemWrapper)
+	wrapper.PendingDelete = true
+	w.lruMap.Add(id, wrapper)
+	return nil
+}
+
+// isPendingDelete re-peeks the latest persisted wrapper for id and reports whether DeleteDelayed has been called
+// on it since a Batch containing it was handed to syncCb, e.g. by a concurrent caller while that sync was
+// outstanding.
+func (w *autoRefreshBatchedCache) isPendingDelete(id string) bool {
+	val, ok := w.lruMap.Peek(id)
+	if !ok {
+		return false
+	}
+	return val.(cacheItemWrapper).PendingDelete
+}
+
+func (w *autoRefreshBatchedCache) Watch(id string) (<-chan CacheEvent, CancelFunc) {
+	return w.watchers.watch(id)
+}
+
+func (w *autoRefreshBatchedCache) WatchAll() (<-chan CacheEvent, CancelFunc) {
+	return w.watchers.watchAll()
+}
+
+// enqueueBatches snapshots the tracked items, splits them into Batches and hands them to the workers. It's called
+// once per resyncPeriod tick by runResyncLoop.
+func (w *autoRefreshBatchedCache) enqueueBatches(ctx context.Context) {
+	if w.metrics != nil {
+		w.metrics.Size.Set(float64(w.lruMap.Len()))
+		stop := w.metrics.EnqueueLatency.Start()
+		defer stop.Stop()
+	}
+
+	keys := w.lruMap.Keys()
+	items := make([]CacheItem, 0, len(keys))
+	for _, k := range keys {
+		value, ok := w.lruMap.Peek(k)
+		if !ok {
+			continue
+		}
+
+		wrapper := value.(cacheItemWrapper)
+		id := k.(string)
+
+		if wrapper.PendingDelete {
+			w.lruMap.Remove(k)
+			w.watchers.notify(CacheEvent{Type: Deleted, ID: id, Old: wrapper.CacheItem})
+			continue
+		}
+
+		if w.ttl > 0 && !wrapper.LastSyncedAt.IsZero() && time.Since(wrapper.LastSyncedAt) > w.ttl {
+			logger.Infof(ctx, "Item [%v] exceeded its TTL and will be evicted.", id)
+			w.lruMap.Remove(k)
+			w.watchers.notify(CacheEvent{Type: Deleted, ID: id, Old: wrapper.CacheItem})
+			continue
+		}
+
+		if wrapper.CacheItem.IsTerminal() {
+			if wrapper.TerminalSince.IsZero() {
+				wrapper.TerminalSince = time.Now()
+				w.lruMap.Add(k, wrapper)
+			} else if w.terminalRetention > 0 && time.Since(wrapper.TerminalSince) > w.terminalRetention {
+				w.lruMap.Remove(k)
+				w.watchers.notify(CacheEvent{Type: Deleted, ID: id, Old: wrapper.CacheItem})
+			}
+			continue
+		}
+
+		// id is still being synced as part of a Batch from a previous tick. A Batch waiting out a backoff after a
+		// failure has already released its ids, so those are grouped into this tick's batches as usual. Leaving it
+		// out of this tick's batches avoids grouping it into a second, differently-shaped Batch that could end up
+		// racing the still-outstanding one; it'll be picked up again once that one clears.
+		if _, syncing := w.inFlight.Load(id); syncing {
+			continue
+		}
+
+		items = append(items, wrapper.CacheItem)
+	}
+
+	batches, err := w.createBatches(ctx, items)
+	if err != nil {
+		logger.Errorf(ctx, "Failed to create sync batches: %v", err)
+	} else {
+		for _, batch := range batches {
+			// workqueue.Type dedups its items via a map, and a Batch (a slice) isn't hashable, so it can't be the
+			// enqueued item directly. A pointer to it is hashable by identity and carries the payload along.
+			b := batch
+			w.workqueue.Add(&b)
+		}
+	}
+}
+
+// runWorker pulls Batches off the workqueue and syncs them until the queue is shut down or a newer Start call
+// bumps the generation past gen.
+func (w *autoRefreshBatchedCache) runWorker(ctx context.Context, gen uint64) {
+	for atomic.LoadUint64(&w.generation) == gen && w.processNextBatch(ctx) {
+	}
+}
+
+// claimBatch marks every item in batch as in-flight, guarding against two batches that share an item (e.g. one
+// still backing off from a previous tick and a newer one createBatches formed differently) from being synced
+// concurrently. It either claims all of the batch's items or none of them.
+func (w *autoRefreshBatchedCache) claimBatch(batch Batch) bool {
+	claimed := make([]string, 0, len(batch))
+	for _, item := range batch {
+		if _, alreadySyncing := w.inFlight.LoadOrStore(item.ID(), struct{}{}); alreadySyncing {
+			for _, id := range claimed {
+				w.inFlight.Delete(id)
+			}
+			return false
+		}
+		claimed = append(claimed, item.ID())
+	}
+	return true
+}
+
+func (w *autoRefreshBatchedCache) releaseBatch(batch Batch) {
+	for _, item := range batch {
+		w.inFlight.Delete(item.ID())
+	}
+}
+
+func (w *autoRefreshBatchedCache) processNextBatch(ctx context.Context) bool {
+	obj, shutdown := w.workqueue.Get()
+	if shutdown {
+		return false
+	}
+	defer w.workqueue.Done(obj)
+
+	batchPtr, ok := obj.(*Batch)
+	if !ok {
+		logger.Errorf(ctx, "Unexpected item of type %T on synccache workqueue, dropping.", obj)
+		w.workqueue.Forget(obj)
+		return true
+	}
+	batch := *batchPtr
+
+	// Guards against two batches that overlap on an item (e.g. from overlapping generations, or two differently
+	// shaped batches formed across resync ticks) both syncing that item at once.
+	if !w.claimBatch(batch) {
+		w.workqueue.AddRateLimited(obj)
+		return true
+	}
+	defer w.releaseBatch(batch)
+
+	if err := w.syncRateLimiter.Wait(ctx); err != nil {
+		w.workqueue.AddRateLimited(obj)
+		return true
+	}
+
+	timeSync := w.metrics != nil
+	var batchSyncStop promutils.Timer
+	if timeSync {
+		batchSyncStop = w.metrics.SyncLatency.Start()
+	}
+	resp, err := w.syncCb(ctx, batch)
+	if timeSync {
+		batchSyncStop.Stop()
+	}
+	if err == nil && len(resp) != len(batch) {
+		err = fmt.Errorf("sync function returned %d response(s) for a batch of %d item(s)", len(resp), len(batch))
+	}
+	if err != nil {
+		logger.Infof(ctx, "Failed to sync batch of %d item(s): %v", len(batch), err)
+		if w.metrics != nil {
+			w.metrics.SyncErrors.Inc()
+		}
+		w.workqueue.AddRateLimited(obj)
+		return true
+	}
+
+	for idx, item := range resp {
+		old := batch[idx]
+		id := old.ID()
+		switch item.Action {
+		case Update:
+			// Re-check PendingDelete: a concurrent DeleteDelayed call could have set it while this batch's sync
+			// was outstanding, and blindly writing back a fresh wrapper here would silently clobber it.
+			if w.isPendingDelete(id) {
+				w.lruMap.Remove(id)
+				w.watchers.notify(CacheEvent{Type: Deleted, ID: id, Old: old})
+			} else {
+				w.lruMap.Add(id, cacheItemWrapper{CacheItem: item.Item, LastSyncedAt: time.Now()})
+				w.watchers.notify(CacheEvent{Type: Updated, ID: id, Old: old, New: item.Item})
+			}
+		case Delete:
+			w.lruMap.Remove(id)
+			w.watchers.notify(CacheEvent{Type: Deleted, ID: id, Old: old})
+		default:
+			// Unchanged: still refresh LastSyncedAt so a healthy, actively-confirmed item isn't evicted by WithTTL,
+			// but re-check PendingDelete first for the same reason as the Update case above.
+			if val, ok := w.lruMap.Peek(id); ok {
+				wrapper := val.(cacheItemWrapper)
+				if wrapper.PendingDelete {
+					w.lruMap.Remove(id)
+					w.watchers.notify(CacheEvent{Type: Deleted, ID: id, Old: old})
+				} else {
+					wrapper.LastSyncedAt = time.Now()
+					w.lruMap.Add(id, wrapper)
+				}
+			}
+		}
+	}
+
+	w.workqueue.Forget(obj)
+	return true
+}
+
+// NewAutoRefreshBatchedCache creates an AutoRefreshCache that resyncs in parallel batches rather than one item at a
+// time. createBatches decides how the tracked items are grouped for each resync cycle, and parallelism workers drain
+// the resulting Batches concurrently, calling syncCb once per Batch.
+func NewAutoRefreshBatchedCache(name string, createBatches CreateBatchesFunc, syncCb SyncFunction,
+	syncRateLimiter utils.RateLimiter, resyncPeriod time.Duration, parallelism, size int,
+	scope promutils.Scope, opts ...Option) (AutoRefreshCache, error) {
+
+	var m *metrics
+	var evictionFunction func(key interface{}, value interface{})
+	if scope != nil {
+		m = newMetrics(scope)
+		evictionFunction = getEvictionFunction(m.Evictions)
+	}
+	lruCache, err := lru.NewWithEvict(size, evictionFunction)
+	if err != nil {
+		return nil, err
+	}
+
+	cache := &autoRefreshBatchedCache{
+		name:            name,
+		createBatches:   createBatches,
+		syncCb:          syncCb,
+		lruMap:          lruCache,
+		syncRateLimiter: syncRateLimiter,
+		resyncPeriod:    resyncPeriod,
+		parallelism:     parallelism,
+		scope:           scope,
+		metrics:         m,
+		watchers:        newWatchRegistry(m),
+		workqueue:       workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), name),
+	}
+
+	for _, opt := range opts {
+		opt(cache)
+	}
+
+	return cache, nil
+}
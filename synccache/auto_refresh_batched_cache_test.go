@@ -0,0 +1,239 @@
+package synccache
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/lyft/flytestdlib/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+type testItem struct {
+	id       string
+	val      int
+	terminal bool
+}
+
+func (t testItem) ID() string {
+	return t.id
+}
+
+func (t testItem) IsTerminal() bool {
+	return t.terminal
+}
+
+func pairBatches(_ context.Context, items []CacheItem) ([]Batch, error) {
+	batches := make([]Batch, 0, len(items))
+	for i := 0; i < len(items); i += 2 {
+		end := i + 2
+		if end > len(items) {
+			end = len(items)
+		}
+		batches = append(batches, Batch(items[i:end]))
+	}
+	return batches, nil
+}
+
+func syncBatchUnchanged(_ context.Context, batch Batch) ([]ItemSyncResponse, error) {
+	resp := make([]ItemSyncResponse, len(batch))
+	for i, item := range batch {
+		resp[i] = ItemSyncResponse{Item: item, Action: Unchanged}
+	}
+	return resp, nil
+}
+
+// TestAutoRefreshBatchedCache_StartDoesNotPanic is a regression test: workqueue.Type dedups its items via a map,
+// and enqueueing a Batch (a slice) directly used to panic the moment the resync loop called Add.
+func TestAutoRefreshBatchedCache_StartDoesNotPanic(t *testing.T) {
+	rateLimiter := utils.NewRateLimiter("test_batched", 1000, 10)
+	c, err := NewAutoRefreshBatchedCache("test", pairBatches, syncBatchUnchanged, rateLimiter, 10*time.Millisecond,
+		2, 10, nil)
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	assert.NotPanics(t, func() {
+		c.Start(ctx)
+	})
+
+	for i := 0; i < 4; i++ {
+		_, err := c.GetOrCreate(testItem{id: fmt.Sprintf("%d", i)})
+		assert.NoError(t, err)
+	}
+
+	assert.Eventually(t, func() bool {
+		for i := 0; i < 4; i++ {
+			if _, err := c.Get(fmt.Sprintf("%d", i)); err != nil {
+				return false
+			}
+		}
+		return true
+	}, time.Second, 5*time.Millisecond)
+}
+
+// singleItemBatches puts every item in its own Batch, so a slow sync on one item never blocks the others from
+// being regrouped on the next resync tick.
+func singleItemBatches(_ context.Context, items []CacheItem) ([]Batch, error) {
+	batches := make([]Batch, 0, len(items))
+	for _, item := range items {
+		batches = append(batches, Batch{item})
+	}
+	return batches, nil
+}
+
+// TestAutoRefreshBatchedCache_NoOverlappingSyncForSameItem is a regression test: the in-flight guard used to be
+// keyed on the whole Batch's concatenated item ids, so a slow-to-sync item that got regrouped into a
+// differently-shaped Batch on a later resync tick wasn't detected as a collision, and syncCb could be invoked
+// concurrently for the same item.
+func TestAutoRefreshBatchedCache_NoOverlappingSyncForSameItem(t *testing.T) {
+	var concurrent, maxConcurrent int32
+	blockingSync := func(_ context.Context, batch Batch) ([]ItemSyncResponse, error) {
+		if atomic.AddInt32(&concurrent, 1) > atomic.LoadInt32(&maxConcurrent) {
+			atomic.StoreInt32(&maxConcurrent, atomic.LoadInt32(&concurrent))
+		}
+		defer atomic.AddInt32(&concurrent, -1)
+
+		// Long enough that multiple resync ticks fire while this item is still "in flight".
+		time.Sleep(50 * time.Millisecond)
+
+		resp := make([]ItemSyncResponse, len(batch))
+		for i, item := range batch {
+			resp[i] = ItemSyncResponse{Item: item, Action: Unchanged}
+		}
+		return resp, nil
+	}
+
+	rateLimiter := utils.NewRateLimiter("test_batched_no_overlap", 1000, 10)
+	c, err := NewAutoRefreshBatchedCache("test_no_overlap", singleItemBatches, blockingSync, rateLimiter,
+		10*time.Millisecond, 4, 10, nil)
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	c.Start(ctx)
+
+	_, err = c.GetOrCreate(testItem{id: "1"})
+	assert.NoError(t, err)
+
+	time.Sleep(200 * time.Millisecond)
+	assert.LessOrEqual(t, int(atomic.LoadInt32(&maxConcurrent)), 1,
+		"syncCb should never be invoked concurrently for the same item")
+}
+
+// TestAutoRefreshBatchedCache_DeleteDelayedDuringInFlightSync is a regression test: processNextBatch used to
+// blind-write a cacheItemWrapper after a successful sync (here an Unchanged one), clobbering a PendingDelete set
+// by a concurrent DeleteDelayed call while the batch's sync was outstanding, so the delete was silently lost.
+func TestAutoRefreshBatchedCache_DeleteDelayedDuringInFlightSync(t *testing.T) {
+	syncStarted := make(chan struct{})
+	proceed := make(chan struct{})
+	var once sync.Once
+	blockOnceThenUnchanged := func(_ context.Context, batch Batch) ([]ItemSyncResponse, error) {
+		once.Do(func() {
+			close(syncStarted)
+			<-proceed
+		})
+		resp := make([]ItemSyncResponse, len(batch))
+		for i, item := range batch {
+			resp[i] = ItemSyncResponse{Item: item, Action: Unchanged}
+		}
+		return resp, nil
+	}
+
+	rateLimiter := utils.NewRateLimiter("test_batched_delete_delayed_race", 1000, 10)
+	c, err := NewAutoRefreshBatchedCache("test_delete_delayed_race", singleItemBatches, blockOnceThenUnchanged,
+		rateLimiter, 10*time.Millisecond, 2, 10, nil)
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	c.Start(ctx)
+
+	_, err = c.GetOrCreate(testItem{id: "1"})
+	assert.NoError(t, err)
+
+	<-syncStarted
+	assert.NoError(t, c.DeleteDelayed("1"))
+	close(proceed)
+
+	assert.Eventually(t, func() bool {
+		_, err := c.Get("1")
+		return err == ErrNotFound
+	}, time.Second, 5*time.Millisecond)
+}
+
+func TestAutoRefreshBatchedCache_TerminalRetention(t *testing.T) {
+	rateLimiter := utils.NewRateLimiter("test_batched_terminal", 1000, 10)
+	c, err := NewAutoRefreshBatchedCache("test_terminal", pairBatches, syncBatchUnchanged, rateLimiter,
+		10*time.Millisecond, 2, 10, nil, WithTerminalRetention(30*time.Millisecond))
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	c.Start(ctx)
+
+	_, err = c.GetOrCreate(testItem{id: "terminal-1", terminal: true})
+	assert.NoError(t, err)
+
+	// A terminal item should be evicted once terminalRetention has passed since it was first observed terminal,
+	// even though it was never actually handed to syncCb.
+	assert.Eventually(t, func() bool {
+		_, err := c.Get("terminal-1")
+		return err == ErrNotFound
+	}, time.Second, 5*time.Millisecond)
+}
+
+func syncBatchAlwaysFail(_ context.Context, _ Batch) ([]ItemSyncResponse, error) {
+	return nil, fmt.Errorf("synthetic failure")
+}
+
+func syncBatchShortResponse(_ context.Context, _ Batch) ([]ItemSyncResponse, error) {
+	// Deliberately returns fewer responses than the batch had items.
+	return []ItemSyncResponse{}, nil
+}
+
+func TestAutoRefreshBatchedCache_SyncResponseLengthMismatchDoesNotPanic(t *testing.T) {
+	rateLimiter := utils.NewRateLimiter("test_batched_resp_len_mismatch", 1000, 10)
+	c, err := NewAutoRefreshBatchedCache("test_resp_len_mismatch", pairBatches, syncBatchShortResponse, rateLimiter,
+		10*time.Millisecond, 2, 10, nil)
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	c.Start(ctx)
+
+	// A SyncFunction returning fewer (or more) responses than the Batch it was given used to panic the worker
+	// goroutine by indexing batch[idx] for every response; it should instead be treated like any other sync error.
+	assert.NotPanics(t, func() {
+		_, err := c.GetOrCreate(testItem{id: "1"})
+		assert.NoError(t, err)
+
+		time.Sleep(50 * time.Millisecond)
+		item, err := c.Get("1")
+		assert.NoError(t, err)
+		assert.Equal(t, "1", item.ID())
+	})
+}
+
+func TestAutoRefreshBatchedCache_TTL(t *testing.T) {
+	rateLimiter := utils.NewRateLimiter("test_batched_ttl", 1000, 10)
+	c, err := NewAutoRefreshBatchedCache("test_ttl", pairBatches, syncBatchAlwaysFail, rateLimiter,
+		10*time.Millisecond, 2, 10, nil, WithTTL(30*time.Millisecond))
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	c.Start(ctx)
+
+	_, err = c.GetOrCreate(testItem{id: "1"})
+	assert.NoError(t, err)
+
+	assert.Eventually(t, func() bool {
+		_, err := c.Get("1")
+		return err == ErrNotFound
+	}, time.Second, 5*time.Millisecond)
+}
@@ -3,6 +3,8 @@ package synccache
 import (
 	"context"
 	"fmt"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/lyft/flytestdlib/utils"
@@ -11,7 +13,7 @@ import (
 	"github.com/lyft/flytestdlib/logger"
 	"github.com/lyft/flytestdlib/promutils"
 	"github.com/prometheus/client_golang/prometheus"
-	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/util/workqueue"
 )
 
 var ErrNotFound = fmt.Errorf("item not found in cache")
@@ -28,10 +30,33 @@ type AutoRefreshCache interface {
 
 	// Get object if exists else create it
 	GetOrCreate(item CacheItem) (CacheItem, error)
+
+	// Forget clears any backoff accumulated for id, e.g. after an external event indicates it's worth
+	// retrying immediately instead of waiting out the remainder of its backoff.
+	Forget(id string)
+
+	// NumRequeues returns the number of times id has been requeued for retry after a failed sync.
+	NumRequeues(id string) int
+
+	// DeleteDelayed marks id for removal on the next sync cycle instead of removing it immediately, so in-flight
+	// Get callers still see the last-known state until then. Returns ErrNotFound if id isn't tracked.
+	DeleteDelayed(id string) error
+
+	// Watch subscribes to Added/Updated/Deleted events for a single id. The returned channel is closed once
+	// CancelFunc is called.
+	Watch(id string) (<-chan CacheEvent, CancelFunc)
+
+	// WatchAll subscribes to Added/Updated/Deleted events for every id in the cache.
+	WatchAll() (<-chan CacheEvent, CancelFunc)
 }
 
 type CacheItem interface {
 	ID() string
+
+	// IsTerminal returns true once the item has reached a final state and will never change again, e.g. a
+	// completed workflow node. The sync loop stops invoking SyncFunction for terminal items and instead evicts
+	// them after WithTerminalRetention's duration, since there's nothing left to refresh.
+	IsTerminal() bool
 }
 
 // Possible actions for the cache to take as a result of running the sync function on any given cache item
@@ -47,17 +72,44 @@ const (
 	Delete
 )
 
-// Your implementation of this function for your cache instance is responsible for returning
-//   1. The new CacheItem, and
-//   2. What action should be taken.  The sync function has no insight into your object, and needs to be
-//      told explicitly if the new item is different from the old one.
-type SyncFunction func(ctx context.Context, obj CacheItem) (
-	newItem CacheItem, result CacheSyncAction, err error)
+// Batch is a collection of cache items that should be synced together in a single upstream call, e.g. when the
+// backing system exposes a bulk lookup API and issuing one RPC per item would be wasteful.
+type Batch []CacheItem
+
+// ItemSyncResponse carries the outcome of syncing a single item that was part of a Batch.
+type ItemSyncResponse struct {
+	// Item is the new CacheItem.
+	Item CacheItem
+
+	// Action is what should be taken for Item. The sync function has no insight into your object, and needs to be
+	// told explicitly if the new item is different from the old one.
+	Action CacheSyncAction
+}
+
+// Your implementation of this function for your cache instance is responsible for returning a response per item in
+// the Batch, in the same order the items were provided. A single error fails the whole batch, and every item in it
+// is retried together on the next sync.
+type SyncFunction func(ctx context.Context, batch Batch) (resp []ItemSyncResponse, err error)
 
 type cacheItemWrapper struct {
 	CacheItem
-	RetryCount int
-	SyncError  error
+	SyncError error
+
+	// LastSyncedAt is refreshed every time the item is inserted or successfully synced, and is the basis for
+	// WithTTL expiry.
+	LastSyncedAt time.Time
+
+	// TerminalSince records when the item was first observed to be terminal, and is the basis for
+	// WithTerminalRetention expiry. Zero until the item is first seen as terminal.
+	TerminalSince time.Time
+
+	// PendingDelete is set by DeleteDelayed and processed by the next sync cycle.
+	PendingDelete bool
+
+	// RetriesExhausted is set once an item has failed to sync more than maxRetries times in a row, and stops
+	// enqueueResync from re-adding it for another sync; a pending DeleteDelayed or an expired TTL still gets it
+	// removed. Cleared by Forget, which gives the item a clean slate to be resynced again.
+	RetriesExhausted bool
 }
 
 func getEvictionFunction(counter prometheus.Counter) func(key interface{}, value interface{}) {
@@ -73,24 +125,157 @@ func getEvictionFunction(counter prometheus.Counter) func(key interface{}, value
 //
 // Sync is run as a fixed-interval-scheduled-task, and is skipped if sync from previous cycle is still running.
 type autoRefreshCache struct {
-	syncCb          SyncFunction
-	lruMap          *lru.Cache
-	syncRateLimiter utils.RateLimiter
-	resyncPeriod    time.Duration
-	scope           promutils.Scope
-	maxRetries      int
+	syncCb            SyncFunction
+	lruMap            *lru.Cache
+	syncRateLimiter   utils.RateLimiter
+	resyncPeriod      time.Duration
+	scope             promutils.Scope
+	metrics           *metrics
+	maxRetries        int
+	workqueue         workqueue.RateLimitingInterface
+	ttl               time.Duration
+	terminalRetention time.Duration
+	watchers          *watchRegistry
+
+	// generation is bumped on every call to Start. A background goroutine from an earlier Start call notices its
+	// captured generation is stale and exits instead of running alongside the new one against the same lruMap.
+	generation uint64
+
+	// inFlight tracks keys currently being synced, guarding against two goroutines (e.g. from overlapping
+	// generations) invoking syncCb for the same key at once.
+	inFlight sync.Map
+
+	// shutdownOnce guards the workqueue's ShutDown against being called more than once, since every Start call
+	// registers its own ctx.Done() goroutine that calls it.
+	shutdownOnce sync.Once
+}
+
+// optionable is implemented by every AutoRefreshCache construction target, letting a single Option type configure
+// either autoRefreshCache or autoRefreshBatchedCache.
+type optionable interface {
+	setTTL(d time.Duration)
+	setTerminalRetention(d time.Duration)
+}
+
+// Option customizes an autoRefreshCache or autoRefreshBatchedCache at construction time.
+type Option func(optionable)
+
+// WithTTL evicts an item once d has passed since it was last inserted or successfully synced, regardless of
+// whether it's terminal. A zero TTL (the default) means items are never evicted on account of age alone.
+func WithTTL(d time.Duration) Option {
+	return func(c optionable) {
+		c.setTTL(d)
+	}
+}
+
+// WithTerminalRetention keeps a terminal item (see CacheItem.IsTerminal) around for d after it was first observed
+// to be terminal, then evicts it. A zero duration (the default) means terminal items are kept until evicted by the
+// LRU or reclaimed by WithTTL.
+func WithTerminalRetention(d time.Duration) Option {
+	return func(c optionable) {
+		c.setTerminalRetention(d)
+	}
+}
+
+func (w *autoRefreshCache) setTTL(d time.Duration) {
+	w.ttl = d
+}
+
+func (w *autoRefreshCache) setTerminalRetention(d time.Duration) {
+	w.terminalRetention = d
 }
 
+// Start launches the background resync loop and worker. Calling Start more than once is safe: the goroutines from
+// an earlier call notice the generation they were launched with is no longer current and exit, rather than running
+// alongside the new ones against the same lruMap. This mirrors the fix Consul applied when their cache's Fetching
+// flag conflated "RPC in flight" with "goroutine alive".
 func (w *autoRefreshCache) Start(ctx context.Context) {
-	go wait.Until(func() { w.sync(ctx) }, w.resyncPeriod, ctx.Done())
+	gen := atomic.AddUint64(&w.generation, 1)
+
+	go w.runResyncLoop(ctx, gen)
+	go w.runWorker(ctx, gen)
+	go func() {
+		<-ctx.Done()
+		w.shutdownOnce.Do(w.workqueue.ShutDown)
+	}()
+}
+
+func (w *autoRefreshCache) runResyncLoop(ctx context.Context, gen uint64) {
+	ticker := time.NewTicker(w.resyncPeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if atomic.LoadUint64(&w.generation) != gen {
+				return
+			}
+			w.enqueueResync(ctx)
+		}
+	}
+}
+
+func (w *autoRefreshCache) Forget(id string) {
+	w.workqueue.Forget(id)
+
+	if val, ok := w.lruMap.Peek(id); ok {
+		wrapper := val.(cacheItemWrapper)
+		if wrapper.RetriesExhausted {
+			wrapper.RetriesExhausted = false
+			w.lruMap.Add(id, wrapper)
+		}
+	}
+}
+
+// isPendingDelete re-peeks the latest persisted wrapper for id and reports whether DeleteDelayed has been called
+// on it since wrapper was captured at the top of processNextItem, e.g. by a concurrent caller while a sync was
+// outstanding.
+func (w *autoRefreshCache) isPendingDelete(id string) bool {
+	val, ok := w.lruMap.Peek(id)
+	if !ok {
+		return false
+	}
+	return val.(cacheItemWrapper).PendingDelete
+}
+
+func (w *autoRefreshCache) NumRequeues(id string) int {
+	return w.workqueue.NumRequeues(id)
+}
+
+func (w *autoRefreshCache) DeleteDelayed(id string) error {
+	val, ok := w.lruMap.Peek(id)
+	if !ok {
+		return ErrNotFound
+	}
+
+	wrapper := val.(cacheItemWrapper)
+	wrapper.PendingDelete = true
+	w.lruMap.Add(id, wrapper)
+	return nil
+}
+
+func (w *autoRefreshCache) Watch(id string) (<-chan CacheEvent, CancelFunc) {
+	return w.watchers.watch(id)
+}
+
+func (w *autoRefreshCache) WatchAll() (<-chan CacheEvent, CancelFunc) {
+	return w.watchers.watchAll()
 }
 
 func (w *autoRefreshCache) Get(id string) (CacheItem, error) {
 	if val, ok := w.lruMap.Get(id); ok {
+		if w.metrics != nil {
+			w.metrics.CacheHit.Inc()
+		}
 		wrapper := val.(cacheItemWrapper)
 		return wrapper.CacheItem, wrapper.SyncError
 	}
 
+	if w.metrics != nil {
+		w.metrics.CacheMiss.Inc()
+	}
 	return nil, ErrNotFound
 }
 
@@ -98,67 +283,202 @@ func (w *autoRefreshCache) Get(id string) (CacheItem, error) {
 // Create should be invoked only once. recreating the object is not supported.
 func (w *autoRefreshCache) GetOrCreate(item CacheItem) (CacheItem, error) {
 	if val, ok := w.lruMap.Get(item.ID()); ok {
+		if w.metrics != nil {
+			w.metrics.CacheHit.Inc()
+		}
 		wrapper := val.(cacheItemWrapper)
 		return wrapper.CacheItem, wrapper.SyncError
 	}
 
-	w.lruMap.Add(item.ID(), cacheItemWrapper{CacheItem: item})
+	if w.metrics != nil {
+		w.metrics.CacheMiss.Inc()
+	}
+	w.lruMap.Add(item.ID(), cacheItemWrapper{CacheItem: item, LastSyncedAt: time.Now()})
+	w.watchers.notify(CacheEvent{Type: Added, ID: item.ID(), New: item})
 	return item, nil
 }
 
-// This function is called internally by its own timer. Roughly, it will,
-//  - List keys
-//  - For each of the keys, call syncCb, which tells us if the item has been updated
-//    - If it has, then do a remove followed by an add.  We can get away with this because it is guaranteed that
-//      this loop will run to completion before the next one begins.
+// enqueueResync is called internally by its own timer and pushes every key currently tracked by the cache onto
+// the workqueue so it gets visited by a worker. Items already queued are deduplicated by the queue, and a key
+// waiting out a backoff from a previous failure is left for the rate limiter to re-add, so this never fights with
+// runWorker's retries. An item whose retries have been exhausted is skipped until Forget gives it a clean slate,
+// unless it is pending a DeleteDelayed or past its TTL, in which case it is enqueued so the worker removes it.
+func (w *autoRefreshCache) enqueueResync(ctx context.Context) {
+	if w.metrics != nil {
+		w.metrics.Size.Set(float64(w.lruMap.Len()))
+		stop := w.metrics.EnqueueLatency.Start()
+		defer stop.Stop()
+	}
+
+	for _, k := range w.lruMap.Keys() {
+		if w.workqueue.NumRequeues(k) > 0 {
+			continue
+		}
+
+		if val, ok := w.lruMap.Peek(k); ok {
+			wrapper := val.(cacheItemWrapper)
+			expired := w.ttl > 0 && !wrapper.LastSyncedAt.IsZero() && time.Since(wrapper.LastSyncedAt) > w.ttl
+			if wrapper.RetriesExhausted && !wrapper.PendingDelete && !expired {
+				continue
+			}
+		}
+		w.workqueue.Add(k)
+	}
+}
+
+// runWorker drains the workqueue until it's shut down, processing one key at a time. We can get away with only
+// ever having one key in flight because it's guaranteed that this loop will run to completion before the next one
+// begins.
 //
 // What happens when the number of things that a user is trying to keep track of exceeds the size
 // of the cache?  Trivial case where the cache is size 1 and we're trying to keep track of two things.
-//  * Plugin asks for update on item 1 - cache evicts item 2, stores 1 and returns it unchanged
-//  * Plugin asks for update on item 2 - cache evicts item 1, stores 2 and returns it unchanged
-//  * Sync loop updates item 2, repeat
-func (w *autoRefreshCache) sync(ctx context.Context) {
-	keys := w.lruMap.Keys()
-	for _, k := range keys {
-		// If not ok, it means evicted between the item was evicted between getting the keys and this update loop
-		// which is fine, we can just ignore.
-		if value, ok := w.lruMap.Peek(k); ok {
-			wrapper := value.(cacheItemWrapper)
-			if wrapper.RetryCount > w.maxRetries {
-				logger.Infof(ctx, "Item [%v] exceeded max retries and will not be retried.", k)
-				continue
-			}
+//   - Plugin asks for update on item 1 - cache evicts item 2, stores 1 and returns it unchanged
+//   - Plugin asks for update on item 2 - cache evicts item 1, stores 2 and returns it unchanged
+//   - Sync loop updates item 2, repeat
+func (w *autoRefreshCache) runWorker(ctx context.Context, gen uint64) {
+	for atomic.LoadUint64(&w.generation) == gen && w.processNextItem(ctx) {
+	}
+}
 
-			cacheItem := wrapper.CacheItem.(CacheItem)
-			newItem, result, err := w.syncCb(ctx, cacheItem)
-			if err != nil {
-				logger.Infof(ctx, "Failed to get latest copy of the item %v", k)
-				w.lruMap.Add(k, cacheItemWrapper{
-					CacheItem:  cacheItem,
-					RetryCount: wrapper.RetryCount + 1,
-					SyncError:  err,
-				})
+func (w *autoRefreshCache) processNextItem(ctx context.Context) bool {
+	keyObj, shutdown := w.workqueue.Get()
+	if shutdown {
+		return false
+	}
+	defer w.workqueue.Done(keyObj)
 
-				continue
-			}
+	k := keyObj.(string)
 
-			if result == Update {
-				w.lruMap.Add(k, cacheItemWrapper{CacheItem: newItem})
-			} else if result == Delete {
-				w.lruMap.Remove(k)
-			}
+	// Guards against two goroutines syncing the same key at once, e.g. a worker from a generation that hasn't
+	// yet noticed Start was called again overlapping with the new one.
+	if _, alreadySyncing := w.inFlight.LoadOrStore(k, struct{}{}); alreadySyncing {
+		w.workqueue.AddRateLimited(k)
+		return true
+	}
+	defer w.inFlight.Delete(k)
+
+	// If not ok, it means the item was evicted between being enqueued and this update loop, which is fine, we
+	// can just forget about it.
+	value, ok := w.lruMap.Peek(k)
+	if !ok {
+		w.workqueue.Forget(k)
+		return true
+	}
+
+	wrapper := value.(cacheItemWrapper)
+
+	if w.workqueue.NumRequeues(k) > w.maxRetries {
+		logger.Infof(ctx, "Item [%v] exceeded max retries and will not be retried until Forget is called for it.", k)
+		wrapper.RetriesExhausted = true
+		w.lruMap.Add(k, wrapper)
+		w.workqueue.Forget(k)
+		return true
+	}
+	cacheItem := wrapper.CacheItem.(CacheItem)
+
+	if wrapper.PendingDelete {
+		w.lruMap.Remove(k)
+		w.workqueue.Forget(k)
+		w.watchers.notify(CacheEvent{Type: Deleted, ID: k, Old: cacheItem})
+		return true
+	}
+
+	if w.ttl > 0 && !wrapper.LastSyncedAt.IsZero() && time.Since(wrapper.LastSyncedAt) > w.ttl {
+		logger.Infof(ctx, "Item [%v] exceeded its TTL and will be evicted.", k)
+		w.lruMap.Remove(k)
+		w.workqueue.Forget(k)
+		w.watchers.notify(CacheEvent{Type: Deleted, ID: k, Old: cacheItem})
+		return true
+	}
+
+	if cacheItem.IsTerminal() {
+		if wrapper.TerminalSince.IsZero() {
+			wrapper.TerminalSince = time.Now()
+			w.lruMap.Add(k, wrapper)
+		} else if w.terminalRetention > 0 && time.Since(wrapper.TerminalSince) > w.terminalRetention {
+			w.lruMap.Remove(k)
+			w.workqueue.Forget(k)
+			w.watchers.notify(CacheEvent{Type: Deleted, ID: k, Old: cacheItem})
+			return true
 		}
+		w.workqueue.Forget(k)
+		return true
+	}
+
+	if err := w.syncRateLimiter.Wait(ctx); err != nil {
+		w.workqueue.AddRateLimited(k)
+		return true
 	}
+
+	timeSync := w.metrics != nil
+	var itemSyncStop promutils.Timer
+	if timeSync {
+		itemSyncStop = w.metrics.SyncLatency.Start()
+	}
+	resp, err := w.syncCb(ctx, Batch{cacheItem})
+	if timeSync {
+		itemSyncStop.Stop()
+	}
+
+	if err == nil && len(resp) != 1 {
+		err = fmt.Errorf("sync function returned %d response(s) for a batch of 1 item", len(resp))
+	}
+
+	if err != nil {
+		logger.Infof(ctx, "Failed to get latest copy of the item %v", k)
+		if w.metrics != nil {
+			w.metrics.SyncErrors.Inc()
+		}
+		w.lruMap.Add(k, cacheItemWrapper{
+			CacheItem:    cacheItem,
+			SyncError:    err,
+			LastSyncedAt: wrapper.LastSyncedAt,
+			// Keep a DeleteDelayed that arrived while this sync was outstanding.
+			PendingDelete: w.isPendingDelete(k),
+		})
+		w.workqueue.AddRateLimited(k)
+		return true
+	}
+
+	switch resp[0].Action {
+	case Update:
+		// Re-check PendingDelete: a concurrent DeleteDelayed call could have set it while this sync was
+		// outstanding, and blindly writing back a fresh wrapper here would silently clobber it.
+		if w.isPendingDelete(k) {
+			w.lruMap.Remove(k)
+			w.watchers.notify(CacheEvent{Type: Deleted, ID: k, Old: cacheItem})
+		} else {
+			w.lruMap.Add(k, cacheItemWrapper{CacheItem: resp[0].Item, LastSyncedAt: time.Now()})
+			w.watchers.notify(CacheEvent{Type: Updated, ID: k, Old: cacheItem, New: resp[0].Item})
+		}
+	case Delete:
+		w.lruMap.Remove(k)
+		w.watchers.notify(CacheEvent{Type: Deleted, ID: k, Old: cacheItem})
+	default:
+		// Unchanged: still refresh LastSyncedAt so a healthy, actively-confirmed item isn't evicted by WithTTL, but
+		// re-check PendingDelete first for the same reason as the Update case above.
+		if w.isPendingDelete(k) {
+			w.lruMap.Remove(k)
+			w.watchers.notify(CacheEvent{Type: Deleted, ID: k, Old: cacheItem})
+		} else {
+			w.lruMap.Add(k, cacheItemWrapper{CacheItem: cacheItem, LastSyncedAt: time.Now(), TerminalSince: wrapper.TerminalSince})
+		}
+	}
+
+	w.workqueue.Forget(k)
+	return true
 }
 
-func NewAutoRefreshCache(syncCb SyncFunction, syncRateLimiter utils.RateLimiter,
-	resyncPeriod time.Duration, maxSize, maxRetries int, scope promutils.Scope) (AutoRefreshCache, error) {
+func NewAutoRefreshCache(syncCb SyncFunction, syncRateLimiter utils.RateLimiter, resyncPeriod time.Duration,
+	baseBackoff, maxBackoff time.Duration, maxSize, maxRetries int, scope promutils.Scope,
+	opts ...Option) (AutoRefreshCache, error) {
 
-	// If a scope is specified, we'll add a function to log a metric when an object gets evicted
+	// If a scope is specified, we'll track metrics for this cache, including logging one when an object gets evicted
+	var m *metrics
 	var evictionFunction func(key interface{}, value interface{})
 	if scope != nil {
-		counter := scope.MustNewCounter("lru_evictions", "Counter for evictions from LRU")
-		evictionFunction = getEvictionFunction(counter)
+		m = newMetrics(scope)
+		evictionFunction = getEvictionFunction(m.Evictions)
 	}
 	lruCache, err := lru.NewWithEvict(maxSize, evictionFunction)
 	if err != nil {
@@ -171,8 +491,16 @@ func NewAutoRefreshCache(syncCb SyncFunction, syncRateLimiter utils.RateLimiter,
 		syncRateLimiter: syncRateLimiter,
 		resyncPeriod:    resyncPeriod,
 		scope:           scope,
+		metrics:         m,
 		maxRetries:      maxRetries,
+		watchers:        newWatchRegistry(m),
+		workqueue: workqueue.NewRateLimitingQueue(
+			workqueue.NewItemExponentialFailureRateLimiter(baseBackoff, maxBackoff)),
+	}
+
+	for _, opt := range opts {
+		opt(cache)
 	}
 
 	return cache, nil
-}
\ No newline at end of file
+}
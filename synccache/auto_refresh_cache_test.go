@@ -0,0 +1,206 @@
+package synccache
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/lyft/flytestdlib/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func alwaysUnchanged(_ context.Context, batch Batch) ([]ItemSyncResponse, error) {
+	resp := make([]ItemSyncResponse, len(batch))
+	for i, item := range batch {
+		resp[i] = ItemSyncResponse{Item: item, Action: Unchanged}
+	}
+	return resp, nil
+}
+
+func TestAutoRefreshCache_UnchangedRefreshesLastSyncedAt(t *testing.T) {
+	rateLimiter := utils.NewRateLimiter("test_unchanged", 1000, 10)
+	c, err := NewAutoRefreshCache(alwaysUnchanged, rateLimiter, 10*time.Millisecond, time.Millisecond, time.Millisecond,
+		10, 5, nil, WithTTL(100*time.Millisecond))
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	c.Start(ctx)
+
+	_, err = c.GetOrCreate(testItem{id: "1"})
+	assert.NoError(t, err)
+
+	// An item that keeps syncing Unchanged should never be evicted by its TTL, since every successful sync
+	// should refresh LastSyncedAt.
+	time.Sleep(250 * time.Millisecond)
+	item, err := c.Get("1")
+	assert.NoError(t, err)
+	assert.Equal(t, "1", item.ID())
+}
+
+func TestAutoRefreshCache_RetryBackoff(t *testing.T) {
+	var failures int32
+	failNTimes := func(_ context.Context, batch Batch) ([]ItemSyncResponse, error) {
+		if atomic.AddInt32(&failures, 1) <= 3 {
+			return nil, fmt.Errorf("synthetic failure")
+		}
+		resp := make([]ItemSyncResponse, len(batch))
+		for i, item := range batch {
+			resp[i] = ItemSyncResponse{Item: item, Action: Unchanged}
+		}
+		return resp, nil
+	}
+
+	rateLimiter := utils.NewRateLimiter("test_retry_backoff", 1000, 10)
+	c, err := NewAutoRefreshCache(failNTimes, rateLimiter, 20*time.Millisecond, time.Millisecond, 10*time.Millisecond,
+		10, 5, nil)
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	c.Start(ctx)
+
+	_, err = c.GetOrCreate(testItem{id: "1"})
+	assert.NoError(t, err)
+
+	// Each failure is retried with a growing backoff rather than forgotten outright, so NumRequeues should climb
+	// before eventually dropping back to 0 once a sync succeeds.
+	assert.Eventually(t, func() bool {
+		return atomic.LoadInt32(&failures) > 3 && c.NumRequeues("1") == 0
+	}, time.Second, 5*time.Millisecond)
+}
+
+func TestAutoRefreshCache_SyncResponseLengthMismatchDoesNotPanic(t *testing.T) {
+	emptyResponse := func(_ context.Context, _ Batch) ([]ItemSyncResponse, error) {
+		return nil, nil
+	}
+
+	rateLimiter := utils.NewRateLimiter("test_resp_len_mismatch", 1000, 10)
+	c, err := NewAutoRefreshCache(emptyResponse, rateLimiter, 10*time.Millisecond, time.Millisecond, time.Millisecond,
+		10, 5, nil)
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	c.Start(ctx)
+
+	// A SyncFunction returning fewer responses than the batch it was given used to panic the worker goroutine by
+	// indexing resp[0] unconditionally; it should instead be treated like any other sync error and retried.
+	assert.NotPanics(t, func() {
+		_, err := c.GetOrCreate(testItem{id: "1"})
+		assert.NoError(t, err)
+
+		assert.Eventually(t, func() bool {
+			return c.NumRequeues("1") > 0
+		}, time.Second, 5*time.Millisecond)
+	})
+}
+
+func TestAutoRefreshCache_RetriesExhaustedStopsBeingResynced(t *testing.T) {
+	var attempts int32
+	alwaysFail := func(_ context.Context, _ Batch) ([]ItemSyncResponse, error) {
+		atomic.AddInt32(&attempts, 1)
+		return nil, fmt.Errorf("synthetic failure")
+	}
+
+	rateLimiter := utils.NewRateLimiter("test_retries_exhausted", 1000, 10)
+	// maxRetries of 1 and a short resyncPeriod so several resync ticks fire well within the test's deadline.
+	c, err := NewAutoRefreshCache(alwaysFail, rateLimiter, 10*time.Millisecond, time.Millisecond, 5*time.Millisecond,
+		10, 1, nil)
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	c.Start(ctx)
+
+	_, err = c.GetOrCreate(testItem{id: "1"})
+	assert.NoError(t, err)
+
+	// Once retries are exhausted, the item should stop being resynced altogether rather than being handed a fresh
+	// maxRetries-sized backoff burst on every subsequent resyncPeriod tick.
+	assert.Eventually(t, func() bool {
+		return atomic.LoadInt32(&attempts) >= 2
+	}, time.Second, 5*time.Millisecond)
+
+	settled := atomic.LoadInt32(&attempts)
+	time.Sleep(200 * time.Millisecond)
+	assert.Equal(t, settled, atomic.LoadInt32(&attempts),
+		"a permanently-failing item should stop being retried once maxRetries is exceeded")
+
+	// Forget gives the item a clean slate: it should be picked up and resynced again.
+	c.Forget("1")
+	assert.Eventually(t, func() bool {
+		return atomic.LoadInt32(&attempts) > settled
+	}, time.Second, 5*time.Millisecond)
+}
+
+// TestAutoRefreshCache_DeleteDelayedDuringInFlightSync is a regression test: processNextItem used to blind-write a
+// brand-new cacheItemWrapper on a successful sync, clobbering a PendingDelete set by a concurrent DeleteDelayed
+// call while the sync was outstanding, so the delete was silently lost.
+func TestAutoRefreshCache_DeleteDelayedDuringInFlightSync(t *testing.T) {
+	syncStarted := make(chan struct{})
+	proceed := make(chan struct{})
+	var once sync.Once
+	blockOnceThenUnchanged := func(_ context.Context, batch Batch) ([]ItemSyncResponse, error) {
+		once.Do(func() {
+			close(syncStarted)
+			<-proceed
+		})
+		resp := make([]ItemSyncResponse, len(batch))
+		for i, item := range batch {
+			resp[i] = ItemSyncResponse{Item: item, Action: Unchanged}
+		}
+		return resp, nil
+	}
+
+	rateLimiter := utils.NewRateLimiter("test_delete_delayed_race", 1000, 10)
+	c, err := NewAutoRefreshCache(blockOnceThenUnchanged, rateLimiter, 10*time.Millisecond, time.Millisecond,
+		time.Millisecond, 10, 5, nil)
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	c.Start(ctx)
+
+	_, err = c.GetOrCreate(testItem{id: "1"})
+	assert.NoError(t, err)
+
+	<-syncStarted
+	assert.NoError(t, c.DeleteDelayed("1"))
+	close(proceed)
+
+	assert.Eventually(t, func() bool {
+		_, err := c.Get("1")
+		return err == ErrNotFound
+	}, time.Second, 5*time.Millisecond)
+}
+
+func TestAutoRefreshCache_StartTwiceSupersedesFirstGeneration(t *testing.T) {
+	rateLimiter := utils.NewRateLimiter("test_duplicate_start", 1000, 10)
+	c, err := NewAutoRefreshCache(alwaysUnchanged, rateLimiter, 10*time.Millisecond, time.Millisecond, time.Millisecond,
+		10, 5, nil)
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Calling Start twice used to leave both generations' goroutines running against the same lruMap; the first
+	// generation's goroutines should notice the newer generation and exit instead of racing it. This test only
+	// checks that a second Start call doesn't panic and that the cache keeps serving items afterwards; it doesn't
+	// observe whether the first generation's goroutines have exited.
+	c.Start(ctx)
+	c.Start(ctx)
+
+	assert.NotPanics(t, func() {
+		_, err := c.GetOrCreate(testItem{id: "1"})
+		assert.NoError(t, err)
+
+		assert.Eventually(t, func() bool {
+			item, err := c.Get("1")
+			return err == nil && item.ID() == "1"
+		}, time.Second, 5*time.Millisecond)
+	})
+}
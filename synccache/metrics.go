@@ -0,0 +1,52 @@
+package synccache
+
+import (
+	"time"
+
+	"github.com/lyft/flytestdlib/promutils"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// metrics groups the observability signals emitted by an AutoRefreshCache. Before this, the only signal an
+// operator had into a running cache was the lru_evictions counter.
+type metrics struct {
+	// SyncErrors counts syncCb invocations that returned a non-nil error.
+	SyncErrors prometheus.Counter
+
+	// EnqueueLatency times the per-cycle bookkeeping pass that snapshots tracked items and hands them to the
+	// workqueue, as distinct from SyncLatency's per-item/per-batch syncCb timing.
+	EnqueueLatency promutils.StopWatch
+
+	// SyncLatency times a single syncCb invocation, i.e. one item (autoRefreshCache) or one Batch
+	// (autoRefreshBatchedCache).
+	SyncLatency promutils.StopWatch
+
+	// CacheHit counts Get/GetOrCreate calls that found an already-tracked item.
+	CacheHit prometheus.Counter
+
+	// CacheMiss counts Get/GetOrCreate calls that found no tracked item.
+	CacheMiss prometheus.Counter
+
+	// Size is sampled from the LRU's current length on every resync.
+	Size prometheus.Gauge
+
+	// Evictions counts every item removed from the LRU: both ones dropped to make room for new ones and ones removed
+	// by the cache itself (a Delete sync result, DeleteDelayed, TTL or terminal retention).
+	Evictions prometheus.Counter
+
+	// DroppedEvents counts CacheEvents that couldn't be delivered because a watcher's buffer was full.
+	DroppedEvents prometheus.Counter
+}
+
+func newMetrics(scope promutils.Scope) *metrics {
+	return &metrics{
+		SyncErrors:     scope.MustNewCounter("sync_errors", "Count of syncCb invocations that returned an error"),
+		EnqueueLatency: scope.MustNewStopWatch("enqueue_latency", "Time taken to snapshot tracked items and enqueue them for resync", time.Millisecond),
+		SyncLatency:    scope.MustNewStopWatch("sync_latency", "Time taken for a single syncCb invocation", time.Millisecond),
+		CacheHit:       scope.MustNewCounter("cache_hit", "Count of Get/GetOrCreate calls that found an existing item"),
+		CacheMiss:      scope.MustNewCounter("cache_miss", "Count of Get/GetOrCreate calls that found no existing item"),
+		Size:           scope.MustNewGauge("cache_size", "Number of items currently tracked by the cache"),
+		Evictions:      scope.MustNewCounter("lru_evictions", "Counter for evictions from LRU"),
+		DroppedEvents:  scope.MustNewCounter("watch_dropped_events", "Count of CacheEvents dropped because a watcher's buffer was full"),
+	}
+}
@@ -0,0 +1,125 @@
+package synccache
+
+import "sync"
+
+// EventType enumerates the kinds of change a CacheEvent can describe.
+type EventType int
+
+const (
+	// Added is sent when an item is tracked by the cache for the first time.
+	Added EventType = iota
+
+	// Updated is sent when a sync changes an item.
+	Updated
+
+	// Deleted is sent when an item is removed from the cache, whether by the sync loop, DeleteDelayed, a TTL, or
+	// terminal retention expiring.
+	Deleted
+)
+
+// CacheEvent describes a single change to an item tracked by the cache, modeled on k8s client-go's shared
+// informers. Old is nil for Added, New is nil for Deleted.
+type CacheEvent struct {
+	Type EventType
+	ID   string
+	Old  CacheItem
+	New  CacheItem
+}
+
+// CancelFunc unsubscribes a watcher obtained from Watch/WatchAll and releases its channel.
+type CancelFunc func()
+
+// watcherBufferSize bounds how many undelivered CacheEvents a single watcher can accumulate before new ones are
+// dropped. Watchers are expected to keep up; this just protects the cache from a stalled subscriber.
+const watcherBufferSize = 32
+
+type watcher struct {
+	id string // empty string means this watcher came from WatchAll
+	ch chan CacheEvent
+
+	// closeOnce guards ch against being closed more than once, since CancelFunc is a plain func a caller could
+	// invoke twice.
+	closeOnce sync.Once
+}
+
+// watchRegistry fans CacheEvents for a single AutoRefreshCache out to its subscribers. Sends are non-blocking: a
+// watcher that isn't keeping up loses events, which are counted in metrics.DroppedEvents instead of blocking the
+// sync loop.
+type watchRegistry struct {
+	mu      sync.Mutex
+	byID    map[string][]*watcher
+	all     []*watcher
+	metrics *metrics
+}
+
+func newWatchRegistry(m *metrics) *watchRegistry {
+	return &watchRegistry{
+		byID:    make(map[string][]*watcher),
+		metrics: m,
+	}
+}
+
+func (r *watchRegistry) watch(id string) (<-chan CacheEvent, CancelFunc) {
+	w := &watcher{id: id, ch: make(chan CacheEvent, watcherBufferSize)}
+
+	r.mu.Lock()
+	r.byID[id] = append(r.byID[id], w)
+	r.mu.Unlock()
+
+	return w.ch, func() {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+
+		ws := r.byID[id]
+		for i, existing := range ws {
+			if existing == w {
+				r.byID[id] = append(ws[:i], ws[i+1:]...)
+				break
+			}
+		}
+		w.closeOnce.Do(func() { close(w.ch) })
+	}
+}
+
+func (r *watchRegistry) watchAll() (<-chan CacheEvent, CancelFunc) {
+	w := &watcher{ch: make(chan CacheEvent, watcherBufferSize)}
+
+	r.mu.Lock()
+	r.all = append(r.all, w)
+	r.mu.Unlock()
+
+	return w.ch, func() {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+
+		for i, existing := range r.all {
+			if existing == w {
+				r.all = append(r.all[:i], r.all[i+1:]...)
+				break
+			}
+		}
+		w.closeOnce.Do(func() { close(w.ch) })
+	}
+}
+
+func (r *watchRegistry) notify(event CacheEvent) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, w := range r.byID[event.ID] {
+		r.send(w, event)
+	}
+	for _, w := range r.all {
+		r.send(w, event)
+	}
+}
+
+func (r *watchRegistry) send(w *watcher, event CacheEvent) {
+	select {
+	case w.ch <- event:
+	default:
+		if r.metrics != nil {
+			r.metrics.DroppedEvents.Inc()
+		}
+	}
+}
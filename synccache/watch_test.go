@@ -0,0 +1,88 @@
+package synccache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/lyft/flytestdlib/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestWatchRegistry_CancelTwiceDoesNotPanic is a regression test: CancelFunc used to unconditionally close the
+// watcher's channel, so calling it a second time (e.g. from two goroutines racing to unsubscribe) would panic with
+// "close of closed channel".
+func TestWatchRegistry_CancelTwiceDoesNotPanic(t *testing.T) {
+	r := newWatchRegistry(nil)
+
+	_, cancel := r.watch("1")
+	assert.NotPanics(t, func() {
+		cancel()
+		cancel()
+	})
+
+	_, cancelAll := r.watchAll()
+	assert.NotPanics(t, func() {
+		cancelAll()
+		cancelAll()
+	})
+}
+
+func TestWatchRegistry_WatchAndWatchAll(t *testing.T) {
+	r := newWatchRegistry(nil)
+
+	ch, cancel := r.watch("1")
+	defer cancel()
+
+	chAll, cancelAll := r.watchAll()
+	defer cancelAll()
+
+	r.notify(CacheEvent{Type: Added, ID: "1"})
+	r.notify(CacheEvent{Type: Added, ID: "2"})
+
+	event := <-ch
+	assert.Equal(t, "1", event.ID)
+
+	// watchAll sees both events, watch("1") only sees its own.
+	first := <-chAll
+	second := <-chAll
+	assert.Equal(t, "1", first.ID)
+	assert.Equal(t, "2", second.ID)
+
+	select {
+	case <-ch:
+		t.Fatal("watch(\"1\") should not have received the event for id 2")
+	default:
+	}
+}
+
+func TestAutoRefreshCache_DeleteDelayedNotifiesWatchers(t *testing.T) {
+	rateLimiter := utils.NewRateLimiter("test_watch_delete", 1000, 10)
+	c, err := NewAutoRefreshCache(alwaysUnchanged, rateLimiter, 10*time.Millisecond, time.Millisecond, time.Millisecond,
+		10, 5, nil)
+	assert.NoError(t, err)
+
+	ctx, cancelCtx := context.WithCancel(context.Background())
+	defer cancelCtx()
+	c.Start(ctx)
+
+	_, err = c.GetOrCreate(testItem{id: "1"})
+	assert.NoError(t, err)
+
+	ch, cancel := c.Watch("1")
+	defer cancel()
+
+	assert.NoError(t, c.DeleteDelayed("1"))
+
+	assert.Eventually(t, func() bool {
+		select {
+		case event := <-ch:
+			return event.Type == Deleted && event.ID == "1"
+		default:
+			return false
+		}
+	}, time.Second, 5*time.Millisecond)
+
+	_, err = c.Get("1")
+	assert.Equal(t, ErrNotFound, err)
+}